@@ -1,36 +1,63 @@
 package nsync
 
-import "sync"
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"sync"
+)
 
 // OnceMutex is a mutex that can be locked only once.
 // Lock operation returns true if mutex has been successfully locked.
 // Any other concurrent attempts will block until mutex is unlocked.
 // However, any other attempts to grab a lock will return false.
 type OnceMutex struct {
-	mu   sync.Mutex
-	used bool
+	token chan struct{}
+	done  chan struct{}
 }
 
 func NewOnceMutex() *OnceMutex {
-	return &OnceMutex{}
+	m := &OnceMutex{
+		token: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	m.token <- struct{}{}
+	return m
 }
 
 // Lock tries to acquire lock.
 func (this *OnceMutex) Lock() bool {
-	this.mu.Lock()
-	if this.used {
-		this.mu.Unlock()
+	select {
+	case <-this.token:
+		return true
+	case <-this.done:
 		return false
 	}
-	return true
+}
+
+// LockCtx tries to acquire lock, blocking until it succeeds, the mutex is
+// unlocked by someone else, or ctx is done. Returns ctx.Err() if ctx is done
+// before either of the former happens.
+func (this *OnceMutex) LockCtx(ctx context.Context) (bool, error) {
+	select {
+	case <-this.token:
+		return true, nil
+	case <-this.done:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
 }
 
 // Unlock tries to release a lock.
 func (this *OnceMutex) Unlock() {
-	this.used = true
-	this.mu.Unlock()
+	close(this.done)
 }
 
+// defaultNamedOnceMutexShards is the shard count used by NewNamedOnceMutex.
+const defaultNamedOnceMutexShards = 32
+
 // NamedOnceMutex is a map of dynamically created mutexes by provided id.
 // First attempt to lock by id will create a new mutex and acquire a lock.
 // All other concurrent attempts will block waiting mutex to be unlocked for the same id.
@@ -38,44 +65,132 @@ func (this *OnceMutex) Unlock() {
 // Unlocked mutex is discarded. Next attempt to acquire a lock for the same id will succeed.
 // Such behaviour may be used to refresh a local cache of data identified by some key avoiding
 // concurrent request to receive a refreshed value for the same key.
-
+//
+// Keys are distributed across a fixed number of shards, each guarded by its
+// own mutex, so that Lock/Unlock calls for unrelated keys don't serialize
+// against each other under high key cardinality.
 type NamedOnceMutex struct {
-	lockMap map[interface{}]*OnceMutex
+	shards []*nomShard
+	mask   uint64
+	seed   maphash.Seed
+}
+
+type nomShard struct {
 	mutex   sync.Mutex
+	lockMap map[interface{}]*OnceMutex
 }
 
+// NewNamedOnceMutex returns a NamedOnceMutex sized for high-cardinality key
+// workloads. Use NewNamedOnceMutexSharded to tune the shard count.
 func NewNamedOnceMutex() *NamedOnceMutex {
-	return &NamedOnceMutex{
-		lockMap: make(map[interface{}]*OnceMutex),
+	return NewNamedOnceMutexSharded(defaultNamedOnceMutexShards)
+}
+
+// NewNamedOnceMutexSharded returns a NamedOnceMutex backed by the given
+// number of shards, rounded up to the next power of two. More shards
+// reduce contention between unrelated keys at the cost of a little more
+// memory; one shard reproduces the behaviour of a single global lock.
+func NewNamedOnceMutexSharded(shards int) *NamedOnceMutex {
+	shards = nextPowerOfTwo(shards)
+	ss := make([]*nomShard, shards)
+	for i := range ss {
+		ss[i] = &nomShard{lockMap: make(map[interface{}]*OnceMutex)}
 	}
+	return &NamedOnceMutex{shards: ss, mask: uint64(shards - 1), seed: maphash.MakeSeed()}
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// shardFor picks the shard responsible for useMutexKey. Common key types are
+// hashed directly into a stack-allocated maphash.Hash, with no allocation or
+// reflection-based formatting on the hot path; uncommon key types fall back
+// to hashing their string representation.
+func (this *NamedOnceMutex) shardFor(useMutexKey interface{}) *nomShard {
+	var h maphash.Hash
+	h.SetSeed(this.seed)
+
+	var buf [8]byte
+	switch v := useMutexKey.(type) {
+	case string:
+		h.WriteString(v)
+	case []byte:
+		h.Write(v)
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case int32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case uint:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case uint32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	default:
+		h.WriteString(fmt.Sprintf("%v", v))
+	}
+	return this.shards[h.Sum64()&this.mask]
 }
 
 // Lock try to acquire a lock for provided id. If attempt is successful, true is returned
 // If lock is already acquired by something else it will block until mutex is unlocked returning false.
 func (this *NamedOnceMutex) Lock(useMutexKey interface{}) bool {
-	this.mutex.Lock()
-	m, ok := this.lockMap[useMutexKey]
+	s := this.shardFor(useMutexKey)
+	s.mutex.Lock()
+	m, ok := s.lockMap[useMutexKey]
 	if ok {
-		this.mutex.Unlock()
+		s.mutex.Unlock()
 		return m.Lock()
 	}
 
-	m = &OnceMutex{}
+	m = NewOnceMutex()
 	m.Lock()
-	this.lockMap[useMutexKey] = m
-	this.mutex.Unlock()
+	s.lockMap[useMutexKey] = m
+	s.mutex.Unlock()
 	return true
 }
 
+// LockCtx is the context-aware equivalent of Lock. It returns ctx.Err() if
+// ctx is done before the lock is acquired or released by someone else.
+func (this *NamedOnceMutex) LockCtx(ctx context.Context, useMutexKey interface{}) (bool, error) {
+	s := this.shardFor(useMutexKey)
+	s.mutex.Lock()
+	m, ok := s.lockMap[useMutexKey]
+	if ok {
+		s.mutex.Unlock()
+		return m.LockCtx(ctx)
+	}
+
+	m = NewOnceMutex()
+	m.Lock()
+	s.lockMap[useMutexKey] = m
+	s.mutex.Unlock()
+	return true, nil
+}
+
 // Unlock unlocks the locked mutex. Used mutex will be discarded.
 func (this *NamedOnceMutex) Unlock(useMutexKey interface{}) {
-	this.mutex.Lock()
-	m, ok := this.lockMap[useMutexKey]
+	s := this.shardFor(useMutexKey)
+	s.mutex.Lock()
+	m, ok := s.lockMap[useMutexKey]
 	if ok {
-		delete(this.lockMap, useMutexKey)
-		this.mutex.Unlock()
+		delete(s.lockMap, useMutexKey)
+		s.mutex.Unlock()
 		m.Unlock()
 	} else {
-		this.mutex.Unlock()
+		s.mutex.Unlock()
 	}
 }
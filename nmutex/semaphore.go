@@ -0,0 +1,224 @@
+// Semaphore implementation that adds so necessary synchronization
+// primitive into Go language. It uses an internal waiter queue protected
+// by a mutex so that weighted acquisitions can be satisfied atomically.
+
+package nmutex
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// waiter represents a pending AcquireN/TryAcquireNTimeout call. ready is
+// closed once n units have been reserved on its behalf.
+type waiter struct {
+	n     int
+	ready chan struct{}
+}
+
+// Semaphore is a weighted semaphore: each unit held counts against its
+// configured capacity, and more than one unit may be reserved in a single
+// call.
+//
+// By default a TryAcquire* call may succeed ahead of goroutines already
+// blocked in Acquire/AcquireN whenever capacity happens to be free, which
+// favors throughput. Under heavy contention that can starve long-waiting
+// callers the same way an unfair sync.Mutex can; use NewSemaphoreFair when
+// FIFO wake order matters more than raw throughput.
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int
+	cur     int
+	fair    bool
+	waiters list.List
+}
+
+// NewSemaphore returns an instance of a semaphore with the given capacity.
+// TryAcquire* calls on it may barge ahead of blocked waiters; see
+// NewSemaphoreFair for strict FIFO ordering instead.
+func NewSemaphore(value int) *Semaphore {
+	return &Semaphore{size: value}
+}
+
+// NewSemaphoreFair returns an instance of a semaphore with the given
+// capacity that guarantees FIFO wake ordering: Release hands freed capacity
+// directly to the longest-waiting caller, TryAcquire/TryAcquireN calls
+// fail immediately whenever another caller is already queued, and
+// TryAcquireTimeout/TryAcquireNTimeout calls queue behind it, rather than
+// stealing its slot. This trades some throughput for starvation-freedom.
+func NewSemaphoreFair(value int) *Semaphore {
+	return &Semaphore{size: value, fair: true}
+}
+
+// Acquire tries to acquire semaphore lock. If no luck it will block.
+func (s *Semaphore) Acquire() {
+	s.AcquireN(1)
+}
+
+// AcquireN reserves n units, blocking until they are available.
+func (s *Semaphore) AcquireN(n int) {
+	s.mu.Lock()
+	if s.acquireFastPathLocked(n) {
+		s.mu.Unlock()
+		return
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	s.waiters.PushBack(w)
+	s.mu.Unlock()
+	<-w.ready
+}
+
+// AcquireCtx tries to acquire semaphore lock, blocking until it succeeds or
+// ctx is done. Returns ctx.Err() if ctx is done before the lock is acquired.
+func (s *Semaphore) AcquireCtx(ctx context.Context) error {
+	s.mu.Lock()
+	if s.acquireFastPathLocked(1) {
+		s.mu.Unlock()
+		return nil
+	}
+	w := &waiter{n: 1, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Acquired concurrently with the cancellation; give the units back.
+			s.mu.Unlock()
+			s.ReleaseN(1)
+		default:
+			s.waiters.Remove(elem)
+			s.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// Value reports the number of units currently held.
+func (s *Semaphore) Value() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// Release releases one acquired unit. If semaphore is not acquired it will panic.
+func (s *Semaphore) Release() {
+	s.ReleaseN(1)
+}
+
+// ReleaseN releases n acquired units. If fewer than n units are held it will panic.
+func (s *Semaphore) ReleaseN(n int) {
+	s.mu.Lock()
+	if n > s.cur {
+		s.mu.Unlock()
+		panic("No semafore locks!")
+	}
+	s.cur -= n
+	s.wakeWaitersLocked()
+	s.mu.Unlock()
+}
+
+// TryAcquire tries to acquire semaphore. Returns true/false if success/failure accordingly.
+func (s *Semaphore) TryAcquire() bool {
+	return s.TryAcquireN(1)
+}
+
+// TryAcquireN tries to reserve n units without blocking.
+// Returns true/false if success/failure accordingly.
+func (s *Semaphore) TryAcquireN(n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tryAcquireOpportunisticLocked(n)
+}
+
+// TryAcquireTimeout tries to acquire semaphore for a specified time interval.
+// Returns true/false if success/failure accordingly.
+func (s *Semaphore) TryAcquireTimeout(d time.Duration) bool {
+	return s.TryAcquireNTimeout(1, d)
+}
+
+// TryAcquireNTimeout tries to reserve n units, waiting up to d for them to
+// become available. Returns true/false if success/failure accordingly.
+func (s *Semaphore) TryAcquireNTimeout(n int, d time.Duration) bool {
+	s.mu.Lock()
+	if s.tryAcquireOpportunisticLocked(n) {
+		s.mu.Unlock()
+		return true
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-time.After(d):
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Acquired concurrently with the timeout; give the units back.
+			s.mu.Unlock()
+			s.ReleaseN(n)
+		default:
+			s.waiters.Remove(elem)
+			// Waiters queued behind this one may fit the free capacity now.
+			s.wakeWaitersLocked()
+			s.mu.Unlock()
+		}
+		return false
+	}
+}
+
+// acquireFastPathLocked reserves n units for a blocking Acquire/AcquireCtx
+// call without parking it, but only when no one else is already queued -
+// a call willing to block gains nothing by cutting ahead of an existing
+// waiter, so this always respects FIFO order regardless of fair mode.
+// s.mu must be held.
+func (s *Semaphore) acquireFastPathLocked(n int) bool {
+	if s.waiters.Len() == 0 && s.cur+n <= s.size {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// tryAcquireOpportunisticLocked reserves n units for a non-blocking
+// TryAcquire*/TryAcquireN call. On a default (non-fair) Semaphore it may
+// grab free capacity even if other callers are already queued, trading
+// fairness for throughput; on a fair Semaphore it defers to queued waiters
+// like acquireFastPathLocked does. s.mu must be held.
+func (s *Semaphore) tryAcquireOpportunisticLocked(n int) bool {
+	if !s.fair {
+		if s.cur+n <= s.size {
+			s.cur += n
+			return true
+		}
+		return false
+	}
+	return s.acquireFastPathLocked(n)
+}
+
+// wakeWaitersLocked hands freed capacity to queued waiters in FIFO order,
+// stopping at the first waiter whose weight doesn't yet fit so that a large
+// request isn't starved by a stream of smaller ones. s.mu must be held.
+func (s *Semaphore) wakeWaitersLocked() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*waiter)
+		if s.cur+w.n > s.size {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
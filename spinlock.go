@@ -0,0 +1,58 @@
+package nsync
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spinUnlocked uint32 = iota
+	spinLocked
+)
+
+// SpinLock is a mutex backed by a single CAS loop over an atomic state
+// word, with runtime.Gosched() between failed attempts. It makes no
+// allocations and never parks the goroutine, which makes it cheap to
+// acquire when uncontended but wasteful under heavy contention or for
+// long critical sections - use sync.Mutex or TryMutex in that case.
+type SpinLock struct {
+	state uint32
+}
+
+func NewSpinLock() *SpinLock {
+	return &SpinLock{}
+}
+
+// Lock spins until the lock is acquired.
+func (this *SpinLock) Lock() {
+	for !this.TryLock() {
+		runtime.Gosched()
+	}
+}
+
+// Unlock releases the lock.
+func (this *SpinLock) Unlock() {
+	atomic.StoreUint32(&this.state, spinUnlocked)
+}
+
+// TryLock tries to acquire the lock without blocking.
+// Returns true/false if success/failure accordingly.
+func (this *SpinLock) TryLock() bool {
+	return atomic.CompareAndSwapUint32(&this.state, spinUnlocked, spinLocked)
+}
+
+// TryLockTimeout spins trying to acquire the lock until it succeeds or d
+// elapses. Returns true/false if success/failure accordingly.
+func (this *SpinLock) TryLockTimeout(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for {
+		if this.TryLock() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+}
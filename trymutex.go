@@ -0,0 +1,70 @@
+package nsync
+
+import (
+	"sync"
+	"time"
+)
+
+// TryMutex is a mutex backed by a buffered channel of size 1, which lets
+// callers attempt a non-blocking or time-bounded acquire in addition to
+// the usual blocking Lock. It gives a goroutine a way to back off instead
+// of deadlocking when it can't acquire locks in a consistent order, e.g.
+// one goroutine locking A then B while another locks B then A.
+// The zero value is a usable, unlocked TryMutex.
+type TryMutex struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func NewTryMutex() *TryMutex {
+	m := &TryMutex{}
+	m.init()
+	return m
+}
+
+func (this *TryMutex) init() {
+	this.once.Do(func() {
+		this.ch = make(chan struct{}, 1)
+		this.ch <- struct{}{}
+	})
+}
+
+// Lock acquires the lock, blocking until it is available.
+func (this *TryMutex) Lock() {
+	this.init()
+	<-this.ch
+}
+
+// Unlock releases the lock.
+func (this *TryMutex) Unlock() {
+	this.init()
+	select {
+	case this.ch <- struct{}{}:
+	default:
+		panic("sync: unlock of unlocked TryMutex")
+	}
+}
+
+// TryLock tries to acquire the lock without blocking.
+// Returns true/false if success/failure accordingly.
+func (this *TryMutex) TryLock() bool {
+	this.init()
+	select {
+	case <-this.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryLockTimeout tries to acquire the lock, waiting up to d for it to
+// become available. Returns true/false if success/failure accordingly.
+func (this *TryMutex) TryLockTimeout(d time.Duration) bool {
+	this.init()
+	select {
+	case <-this.ch:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}